@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Codec abstracts the on-disk serialization format used by the Driver.
+// Implementations must be safe for concurrent use.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	Ext() string
+}
+
+// JSONCodec is the default Codec and preserves the historical on-disk
+// format: indented JSON with a ".json" extension.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (JSONCodec) Ext() string {
+	return ".json"
+}
+
+// BSONCodec stores records as compact BSON documents with a ".bson"
+// extension, useful when records are larger or human-readability of
+// the on-disk files doesn't matter.
+type BSONCodec struct{}
+
+func (BSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return bson.Marshal(v)
+}
+
+func (BSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return bson.Unmarshal(data, v)
+}
+
+func (BSONCodec) Ext() string {
+	return ".bson"
+}
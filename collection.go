@@ -0,0 +1,61 @@
+package main
+
+// Collection is a typed handle onto one collection in a Driver, saving
+// callers from re-unmarshalling records by hand after every ReadAll.
+type Collection[T any] struct {
+	driver *Driver
+	name   string
+}
+
+// NewCollection returns a handle for reading and writing T values in
+// the named collection of d.
+func NewCollection[T any](d *Driver, name string) *Collection[T] {
+	return &Collection[T]{driver: d, name: name}
+}
+
+// Put writes v under id.
+func (c *Collection[T]) Put(id string, v T) error {
+	return c.driver.Write(c.name, id, v)
+}
+
+// Get reads the record stored under id.
+func (c *Collection[T]) Get(id string) (T, error) {
+	var v T
+	err := c.driver.Read(c.name, id, &v)
+	return v, err
+}
+
+// Delete removes the record stored under id.
+func (c *Collection[T]) Delete(id string) error {
+	return c.driver.Delete(c.name, id)
+}
+
+// All decodes and returns every record in the collection.
+func (c *Collection[T]) All() ([]T, error) {
+	var out []T
+	err := c.driver.ReadAllTyped(c.name, &out)
+	return out, err
+}
+
+// Iter decodes each record one at a time, streaming from disk instead
+// of loading the whole collection into memory, and calls fn with its id
+// and value. Iteration stops early if fn returns false.
+//
+// It reads through the driver's ReadAllStream, so each record is read
+// under the same record lock that Read takes, and a record deleted
+// after the collection was listed is skipped rather than reported.
+func (c *Collection[T]) Iter(fn func(id string, v T) bool) error {
+	var decodeErr error
+
+	err := c.driver.ReadAllStream(c.name, func(id string, raw []byte) bool {
+		var v T
+		if decodeErr = c.driver.codec.Unmarshal(raw, &v); decodeErr != nil {
+			return false
+		}
+		return fn(id, v)
+	})
+	if decodeErr != nil {
+		return decodeErr
+	}
+	return err
+}
@@ -0,0 +1,21 @@
+package main
+
+// SyncMode controls how hard the driver works to make a Write durable
+// against a crash, trading throughput for safety.
+type SyncMode int
+
+const (
+	// SyncNone performs no extra fsyncs; a crash between the rename and
+	// the kernel flushing its page cache can still lose the write. This
+	// is the historical behavior and the default.
+	SyncNone SyncMode = iota
+
+	// SyncData fsyncs the record's temp file before it's renamed into
+	// place, so the record's content can't be lost, but the rename
+	// itself may not have hit disk yet.
+	SyncData
+
+	// SyncFull additionally fsyncs the collection directory after the
+	// rename, so the rename itself is durable too.
+	SyncFull
+)
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// atomicRename renames src to dst. On Unix, os.Rename already replaces
+// an existing dst atomically.
+func atomicRename(src, dst string) error {
+	return os.Rename(src, dst)
+}
+
+// fsyncDir flushes dir's directory entry (e.g. the rename that just
+// happened inside it) to disk.
+func fsyncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return unix.Fsync(int(f.Fd()))
+}
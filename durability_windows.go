@@ -0,0 +1,28 @@
+//go:build windows
+
+package main
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// atomicRename renames src to dst, working around os.Rename failing on
+// Windows when dst already exists.
+func atomicRename(src, dst string) error {
+	srcPtr, err := windows.UTF16PtrFromString(src)
+	if err != nil {
+		return err
+	}
+	dstPtr, err := windows.UTF16PtrFromString(dst)
+	if err != nil {
+		return err
+	}
+
+	return windows.MoveFileEx(srcPtr, dstPtr, windows.MOVEFILE_REPLACE_EXISTING|windows.MOVEFILE_WRITE_THROUGH)
+}
+
+// fsyncDir is a no-op on Windows: directories can't be fsynced, and
+// MOVEFILE_WRITE_THROUGH already makes atomicRename's rename durable.
+func fsyncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// indexDirName is the subdirectory (relative to a collection directory)
+// that holds persisted index files.
+const indexDirName = ".idx"
+
+// collectionIndex is a secondary index over one collection, mapping an
+// extracted value to the ids of every record that produced it.
+type collectionIndex struct {
+	mu      sync.RWMutex
+	name    string
+	extract func(raw []byte) (string, error)
+	byValue map[string][]string
+	byID    map[string]string
+}
+
+func newCollectionIndex(name string, extract func(raw []byte) (string, error)) *collectionIndex {
+	return &collectionIndex{
+		name:    name,
+		extract: extract,
+		byValue: make(map[string][]string),
+		byID:    make(map[string]string),
+	}
+}
+
+// putLocked records that id now has value, removing it from its
+// previous bucket if it had one. Callers must hold idx.mu.
+func (idx *collectionIndex) putLocked(id, value string) {
+	if old, ok := idx.byID[id]; ok {
+		if old == value {
+			return
+		}
+		idx.removeFromBucketLocked(old, id)
+	}
+
+	idx.byValue[value] = append(idx.byValue[value], id)
+	idx.byID[id] = value
+}
+
+// removeLocked drops id from the index entirely. Callers must hold idx.mu.
+func (idx *collectionIndex) removeLocked(id string) {
+	value, ok := idx.byID[id]
+	if !ok {
+		return
+	}
+	idx.removeFromBucketLocked(value, id)
+	delete(idx.byID, id)
+}
+
+func (idx *collectionIndex) removeFromBucketLocked(value, id string) {
+	ids := idx.byValue[value]
+	for i, existing := range ids {
+		if existing == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(idx.byValue, value)
+	} else {
+		idx.byValue[value] = ids
+	}
+}
+
+// CreateIndex scans collection, builds an index named name keyed by the
+// value extract returns for each record's raw bytes, and persists it
+// under <dir>/<collection>/.idx/<name>.json.
+func (d *Driver) CreateIndex(collection, name string, extract func(raw []byte) (string, error)) error {
+	if collection == "" {
+		return fmt.Errorf("missing collection - no place to index")
+	}
+	if name == "" {
+		return fmt.Errorf("missing index name")
+	}
+
+	collLock := d.collectionLock(collection)
+	collLock.Lock()
+	defer collLock.Unlock()
+
+	dir := filepath.Join(d.dir, collection)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	ext := d.codec.Ext()
+	idx := newCollectionIndex(name, extract)
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		id := strings.TrimSuffix(file.Name(), ext)
+
+		b, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+
+		value, err := extract(b)
+		if err != nil {
+			return err
+		}
+
+		idx.putLocked(id, value)
+	}
+
+	if err := d.persistIndex(collection, idx); err != nil {
+		return err
+	}
+
+	d.registerIndex(collection, idx)
+	return nil
+}
+
+func (d *Driver) registerIndex(collection string, idx *collectionIndex) {
+	d.indexMu.Lock()
+	defer d.indexMu.Unlock()
+
+	if d.indexes == nil {
+		d.indexes = make(map[string]map[string]*collectionIndex)
+	}
+	if d.indexes[collection] == nil {
+		d.indexes[collection] = make(map[string]*collectionIndex)
+	}
+	d.indexes[collection][idx.name] = idx
+}
+
+func (d *Driver) collectionIndexes(collection string) map[string]*collectionIndex {
+	d.indexMu.Lock()
+	defer d.indexMu.Unlock()
+	return d.indexes[collection]
+}
+
+func (d *Driver) persistIndex(collection string, idx *collectionIndex) error {
+	// Held for the whole write: record writers share the collection
+	// RLock, so two of them can persist the same index at once and
+	// would otherwise race on its .tmp file.
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	b, err := json.MarshalIndent(idx.byValue, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(d.dir, collection, indexDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	fnlPath := filepath.Join(dir, idx.name+".json")
+	tmpPath := fnlPath + ".tmp"
+
+	if err := d.writeFile(tmpPath, b); err != nil {
+		return err
+	}
+
+	if err := atomicRename(tmpPath, fnlPath); err != nil {
+		return err
+	}
+
+	if d.syncMode == SyncFull {
+		if err := fsyncDir(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateIndexesOnWrite keeps every index registered against collection
+// in sync with a record that was just written. Callers must hold the
+// collection mutex.
+func (d *Driver) updateIndexesOnWrite(collection, resources string, payload []byte) error {
+	for _, idx := range d.collectionIndexes(collection) {
+		value, err := idx.extract(payload)
+		if err != nil {
+			return err
+		}
+
+		idx.mu.Lock()
+		idx.putLocked(resources, value)
+		idx.mu.Unlock()
+
+		if err := d.persistIndex(collection, idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateIndexesOnDelete removes resources from every index registered
+// against collection. Callers must hold the collection mutex.
+func (d *Driver) updateIndexesOnDelete(collection, resources string) error {
+	for _, idx := range d.collectionIndexes(collection) {
+		idx.mu.Lock()
+		idx.removeLocked(resources)
+		idx.mu.Unlock()
+
+		if err := d.persistIndex(collection, idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearIndexes drops every index entry for collection, used when the
+// whole collection directory is deleted. Callers must hold the
+// collection mutex.
+func (d *Driver) clearIndexes(collection string) error {
+	for _, idx := range d.collectionIndexes(collection) {
+		idx.mu.Lock()
+		idx.byValue = make(map[string][]string)
+		idx.byID = make(map[string]string)
+		idx.mu.Unlock()
+
+		if err := d.persistIndex(collection, idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find looks up every record whose indexName value equals value and
+// decodes them into the slice pointed to by out.
+func (d *Driver) Find(collection, indexName, value string, out interface{}) error {
+	var ids []string
+
+	if idx := d.collectionIndexes(collection)[indexName]; idx != nil {
+		idx.mu.RLock()
+		ids = append(ids, idx.byValue[value]...)
+		idx.mu.RUnlock()
+	} else {
+		return fmt.Errorf("no index named %q on collection %q", indexName, collection)
+	}
+
+	return d.decodeIDsInto(collection, ids, out)
+}
+
+func (d *Driver) decodeIDsInto(collection string, ids []string, out interface{}) error {
+	outVal := reflect.ValueOf(out)
+	if outVal.Kind() != reflect.Ptr || outVal.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("out must be a pointer to a slice")
+	}
+
+	sliceVal := outVal.Elem()
+	elemType := sliceVal.Type().Elem()
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		recLock := d.recordLock(collection, id)
+		recLock.RLock()
+		b, err := os.ReadFile(filepath.Join(d.dir, collection, id+d.codec.Ext()))
+		recLock.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		elemPtr := reflect.New(elemType)
+		if err := d.codec.Unmarshal(b, elemPtr.Interface()); err != nil {
+			return err
+		}
+
+		sliceVal.Set(reflect.Append(sliceVal, elemPtr.Elem()))
+	}
+
+	return nil
+}
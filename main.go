@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 
 	"github.com/jcelliott/lumber"
@@ -23,15 +25,23 @@ type (
 	}
 
 	Driver struct {
-		mutex   sync.Mutex
-		mutexes map[string]*sync.Mutex
-		dir     string
-		log     Logger
+		mutex       sync.Mutex
+		collLocks   map[string]*sync.RWMutex
+		recordLocks [recordShardCount]sync.RWMutex
+		dir         string
+		log         Logger
+		codec       Codec
+		syncMode    SyncMode
+
+		indexMu sync.Mutex
+		indexes map[string]map[string]*collectionIndex
 	}
 )
 
 type Options struct {
-	Logger Logger
+	Logger   Logger
+	Codec    Codec
+	SyncMode SyncMode
 }
 
 func New(dir string, options *Options) (*Driver, error) {
@@ -46,20 +56,30 @@ func New(dir string, options *Options) (*Driver, error) {
 		opts.Logger = lumber.NewConsoleLogger((lumber.INFO))
 	}
 
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec{}
+	}
+
 	driver := &Driver{
-		dir:     dir,
-		mutexes: make(map[string]*sync.Mutex),
-		log:     opts.Logger,
+		dir:       dir,
+		collLocks: make(map[string]*sync.RWMutex),
+		log:       opts.Logger,
+		codec:     opts.Codec,
+		syncMode:  opts.SyncMode,
 	}
 
 	if _, err := os.Stat(dir); err == nil {
 		opts.Logger.Debug("Using '%s' (database already exists)\n", dir)
-		return driver, nil
+		return driver, driver.recoverWAL()
 	}
 
 	opts.Logger.Debug("Creating the database at '%s'...\n", dir)
 
-	return driver, os.MkdirAll(dir, 0755)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return driver, err
+	}
+
+	return driver, driver.recoverWAL()
 }
 
 func (d *Driver) Write(collection, resources string, v interface{}) error {
@@ -71,29 +91,71 @@ func (d *Driver) Write(collection, resources string, v interface{}) error {
 		return fmt.Errorf("missing resources - unable to save record (no name)")
 	}
 
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+	collLock := d.collectionLock(collection)
+	collLock.RLock()
+	defer collLock.RUnlock()
+
+	recLock := d.recordLock(collection, resources)
+	recLock.Lock()
+	defer recLock.Unlock()
 
+	b, err := d.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return d.writeLocked(collection, resources, b)
+}
+
+// writeLocked performs the tmp+rename write of an already-encoded payload.
+// Callers must hold the collection RLock and the record's Lock.
+func (d *Driver) writeLocked(collection, resources string, payload []byte) error {
 	dir := filepath.Join(d.dir, collection)
-	fnlPath := filepath.Join(dir, resources+".json")
+	fnlPath := filepath.Join(dir, resources+d.codec.Ext())
 	tmpPath := fnlPath + ".tmp"
 
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	b, err := json.MarshalIndent(v, "", "\t")
+	if err := d.writeFile(tmpPath, payload); err != nil {
+		return err
+	}
+
+	if err := atomicRename(tmpPath, fnlPath); err != nil {
+		return err
+	}
+
+	if d.syncMode == SyncFull {
+		if err := fsyncDir(dir); err != nil {
+			return err
+		}
+	}
+
+	return d.updateIndexesOnWrite(collection, resources, payload)
+}
+
+// writeFile writes data to path, fsyncing it first when the driver's
+// SyncMode calls for durable writes.
+func (d *Driver) writeFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
-	b = append(b, byte('\n'))
 
-	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
 		return err
 	}
 
-	return os.Rename(tmpPath, fnlPath)
+	if d.syncMode != SyncNone {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	return f.Close()
 }
 
 func (d *Driver) Read(collection, resources string, v interface{}) error {
@@ -105,18 +167,22 @@ func (d *Driver) Read(collection, resources string, v interface{}) error {
 		return fmt.Errorf("missing resources - no place to read record")
 	}
 
+	recLock := d.recordLock(collection, resources)
+	recLock.RLock()
+	defer recLock.RUnlock()
+
 	record := filepath.Join(d.dir, collection, resources)
 
-	if _, err := stat(record); err != nil {
+	if _, err := d.stat(record); err != nil {
 		return err
 	}
 
-	b, err := os.ReadFile(record + ".json")
+	b, err := os.ReadFile(record + d.codec.Ext())
 	if err != nil {
 		return err
 	}
 
-	return json.Unmarshal(b, &v)
+	return d.codec.Unmarshal(b, v)
 }
 
 func (d *Driver) ReadAll(collection string) ([]string, error) {
@@ -125,7 +191,7 @@ func (d *Driver) ReadAll(collection string) ([]string, error) {
 	}
 
 	dir := filepath.Join(d.dir, collection)
-	if _, err := stat(dir); err != nil {
+	if _, err := d.stat(dir); err != nil {
 		return nil, err
 	}
 	files, err := os.ReadDir(dir)
@@ -136,6 +202,10 @@ func (d *Driver) ReadAll(collection string) ([]string, error) {
 	var records []string
 
 	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
 		b, err := os.ReadFile(filepath.Join(dir, file.Name()))
 		if err != nil {
 			return nil, err
@@ -146,43 +216,157 @@ func (d *Driver) ReadAll(collection string) ([]string, error) {
 	return records, nil
 }
 
-func (d *Driver) Delete(collection, resources string) error {
-	path := filepath.Join(collection, resources)
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+// ReadAllTyped reads every record in collection and decodes it via the
+// driver's active Codec, appending each decoded value to the slice
+// pointed to by out. out must be a non-nil pointer to a slice.
+func (d *Driver) ReadAllTyped(collection string, out interface{}) error {
+	if collection == "" {
+		return fmt.Errorf("missing collection - no place to read records")
+	}
 
-	dir := filepath.Join(d.dir, path)
+	outVal := reflect.ValueOf(out)
+	if outVal.Kind() != reflect.Ptr || outVal.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("out must be a pointer to a slice")
+	}
 
-	switch fi, err := stat(dir); {
-	case fi == nil, err != nil:
-		return fmt.Errorf("unable to find file or directory named %v", path)
-	case fi.Mode().IsDir():
-		return os.RemoveAll(dir)
-	case fi.Mode().IsRegular():
-		return os.RemoveAll(dir + ".json")
+	sliceVal := outVal.Elem()
+	elemType := sliceVal.Type().Elem()
+
+	dir := filepath.Join(d.dir, collection)
+	if _, err := d.stat(dir); err != nil {
+		return err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+
+		elemPtr := reflect.New(elemType)
+		if err := d.codec.Unmarshal(b, elemPtr.Interface()); err != nil {
+			return err
+		}
+
+		sliceVal.Set(reflect.Append(sliceVal, elemPtr.Elem()))
+	}
+
+	return nil
+}
+
+// ReadAllStream calls fn with the resource name and raw bytes of every
+// record in collection, one at a time. Unlike ReadAll, which takes no
+// locks, it holds the collection RLock only while listing the
+// directory; each record is then read under its own record RLock, so
+// concurrent readers of different records never block on each other.
+// A record removed after the listing is skipped. Iteration stops early
+// if fn returns false.
+func (d *Driver) ReadAllStream(collection string, fn func(resources string, raw []byte) bool) error {
+	if collection == "" {
+		return fmt.Errorf("missing collection - no place to read records")
+	}
+
+	collLock := d.collectionLock(collection)
+	collLock.RLock()
+	dir := filepath.Join(d.dir, collection)
+	_, statErr := d.stat(dir)
+	var files []os.DirEntry
+	var err error
+	if statErr == nil {
+		files, err = os.ReadDir(dir)
+	}
+	collLock.RUnlock()
+
+	if statErr != nil {
+		return statErr
+	}
+	if err != nil {
+		return err
+	}
+
+	ext := d.codec.Ext()
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		resources := strings.TrimSuffix(file.Name(), ext)
+
+		recLock := d.recordLock(collection, resources)
+		recLock.RLock()
+		b, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		recLock.RUnlock()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !fn(resources, b) {
+			break
+		}
 	}
 
 	return nil
 }
 
-func (d *Driver) getOrCreateMutex(collection string) *sync.Mutex {
+func (d *Driver) Delete(collection, resources string) error {
+	if resources == "" {
+		collLock := d.collectionLock(collection)
+		collLock.Lock()
+		defer collLock.Unlock()
+
+		return d.deleteLocked(collection, resources)
+	}
 
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
+	collLock := d.collectionLock(collection)
+	collLock.RLock()
+	defer collLock.RUnlock()
 
-	m, ok := d.mutexes[collection]
+	recLock := d.recordLock(collection, resources)
+	recLock.Lock()
+	defer recLock.Unlock()
 
-	if !ok {
-		m = &sync.Mutex{}
-		d.mutexes[collection] = m
+	return d.deleteLocked(collection, resources)
+}
+
+// deleteLocked performs the actual removal. Callers must hold the
+// appropriate collection/record locks.
+func (d *Driver) deleteLocked(collection, resources string) error {
+	path := filepath.Join(collection, resources)
+	dir := filepath.Join(d.dir, path)
+
+	switch fi, err := d.stat(dir); {
+	case fi == nil, err != nil:
+		return fmt.Errorf("unable to find file or directory named %v", path)
+	case fi.Mode().IsDir():
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		return d.clearIndexes(collection)
+	case fi.Mode().IsRegular():
+		if err := os.RemoveAll(dir + d.codec.Ext()); err != nil {
+			return err
+		}
+		return d.updateIndexesOnDelete(collection, resources)
 	}
-	return m
+
+	return nil
 }
 
-func stat(path string) (fi os.FileInfo, err error) {
+func (d *Driver) stat(path string) (fi os.FileInfo, err error) {
 	if fi, err = os.Stat(path); os.IsNotExist(err) {
-		fi, err = os.Stat(path + ".json")
+		fi, err = os.Stat(path + d.codec.Ext())
 	}
 
 	return
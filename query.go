@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type predicateKind int
+
+const (
+	predicateEq predicateKind = iota
+	predicateIn
+	predicatePrefix
+	predicateRange
+)
+
+type predicate struct {
+	kind   predicateKind
+	value  string
+	values []string
+	lo, hi string
+}
+
+func (p predicate) match(value string) bool {
+	switch p.kind {
+	case predicateEq:
+		return value == p.value
+	case predicateIn:
+		for _, v := range p.values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	case predicatePrefix:
+		return strings.HasPrefix(value, p.value)
+	case predicateRange:
+		return value >= p.lo && value <= p.hi
+	}
+	return false
+}
+
+// Query builds a filtered scan over one collection. It plans against a
+// registered index when the predicate supports it (Eq, In) and falls
+// back to a full scan, decoding each record with extract, otherwise.
+type Query struct {
+	driver     *Driver
+	collection string
+	indexName  string
+	extract    func(raw []byte) (string, error)
+	predicate  predicate
+}
+
+// NewQuery starts a query against collection. indexName names a
+// registered index to consult when possible; extract is used to derive
+// the same value directly from a record's raw bytes when a full scan is
+// required.
+func (d *Driver) NewQuery(collection, indexName string, extract func(raw []byte) (string, error)) *Query {
+	return &Query{driver: d, collection: collection, indexName: indexName, extract: extract}
+}
+
+func (q *Query) Eq(value string) *Query {
+	q.predicate = predicate{kind: predicateEq, value: value}
+	return q
+}
+
+func (q *Query) In(values ...string) *Query {
+	q.predicate = predicate{kind: predicateIn, values: values}
+	return q
+}
+
+func (q *Query) Prefix(prefix string) *Query {
+	q.predicate = predicate{kind: predicatePrefix, value: prefix}
+	return q
+}
+
+func (q *Query) Range(lo, hi string) *Query {
+	q.predicate = predicate{kind: predicateRange, lo: lo, hi: hi}
+	return q
+}
+
+// Run invokes fn with the id and raw bytes of every matching record,
+// stopping early if fn returns false.
+func (q *Query) Run(fn func(id string, raw []byte) bool) error {
+	idx := q.driver.collectionIndexes(q.collection)[q.indexName]
+
+	if idx != nil && (q.predicate.kind == predicateEq || q.predicate.kind == predicateIn) {
+		return q.runIndexed(idx, fn)
+	}
+
+	return q.runFullScan(fn)
+}
+
+func (q *Query) runIndexed(idx *collectionIndex, fn func(id string, raw []byte) bool) error {
+	var ids []string
+
+	idx.mu.RLock()
+	switch q.predicate.kind {
+	case predicateEq:
+		ids = append(ids, idx.byValue[q.predicate.value]...)
+	case predicateIn:
+		for _, v := range q.predicate.values {
+			ids = append(ids, idx.byValue[v]...)
+		}
+	}
+	idx.mu.RUnlock()
+
+	for _, id := range ids {
+		recLock := q.driver.recordLock(q.collection, id)
+		recLock.RLock()
+		raw, err := os.ReadFile(filepath.Join(q.driver.dir, q.collection, id+q.driver.codec.Ext()))
+		recLock.RUnlock()
+		if err != nil {
+			return err
+		}
+		if !fn(id, raw) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (q *Query) runFullScan(fn func(id string, raw []byte) bool) error {
+	if q.extract == nil {
+		return fmt.Errorf("query on %q has no usable index and no extract fallback", q.collection)
+	}
+
+	var extractErr error
+
+	err := q.driver.ReadAllStream(q.collection, func(id string, raw []byte) bool {
+		value, err := q.extract(raw)
+		if err != nil {
+			extractErr = err
+			return false
+		}
+
+		if !q.predicate.match(value) {
+			return true
+		}
+
+		return fn(id, raw)
+	})
+	if extractErr != nil {
+		return extractErr
+	}
+	return err
+}
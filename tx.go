@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// walDirName is the subdirectory (relative to the database root) that
+// holds write-ahead log files for in-flight transactions.
+const walDirName = ".wal"
+
+type opKind string
+
+const (
+	opWrite  opKind = "write"
+	opDelete opKind = "delete"
+)
+
+// txOp is one staged operation in a Tx.
+type txOp struct {
+	Kind       opKind
+	Collection string
+	Resource   string
+	Payload    []byte // encoded record bytes; unset for deletes
+}
+
+// walRecord is the on-disk JSON representation of a single txOp inside
+// a WAL file.
+type walRecord struct {
+	Op         opKind
+	Collection string
+	Resource   string
+	Hash       string // hex sha256 of Payload; replay recomputes it from Payload and does not read this field
+	Payload    []byte
+}
+
+// Tx lets a caller stage writes and deletes across one or more
+// collections and apply them atomically via a write-ahead log. A Tx is
+// not safe for concurrent use by multiple goroutines.
+type Tx struct {
+	driver *Driver
+	id     string
+	ops    []txOp
+}
+
+var txCounter uint64
+
+func newTxID() string {
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddUint64(&txCounter, 1))
+}
+
+// Begin starts a new transaction against the driver.
+func (d *Driver) Begin() *Tx {
+	return &Tx{driver: d, id: newTxID()}
+}
+
+// Write stages a record write to be applied on Commit.
+func (t *Tx) Write(collection, resources string, v interface{}) error {
+	if collection == "" {
+		return fmt.Errorf("missing collection - no place to save records")
+	}
+
+	if resources == "" {
+		return fmt.Errorf("missing resources - unable to save record (no name)")
+	}
+
+	b, err := t.driver.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	t.ops = append(t.ops, txOp{Kind: opWrite, Collection: collection, Resource: resources, Payload: b})
+	return nil
+}
+
+// Delete stages a record deletion to be applied on Commit.
+func (t *Tx) Delete(collection, resources string) error {
+	if collection == "" {
+		return fmt.Errorf("missing collection - no place to delete records")
+	}
+
+	if resources == "" {
+		return fmt.Errorf("missing resources - unable to delete record (no name)")
+	}
+
+	t.ops = append(t.ops, txOp{Kind: opDelete, Collection: collection, Resource: resources})
+	return nil
+}
+
+// Rollback discards all staged operations. It is a no-op once Commit
+// has succeeded.
+func (t *Tx) Rollback() {
+	t.ops = nil
+}
+
+// Commit writes every staged operation to a WAL file, fsyncs it, then
+// applies the operations to the store and removes the WAL file. If the
+// process crashes after the fsync but before cleanup, the next call to
+// New for this directory replays the WAL and finishes the commit.
+//
+// Applying is idempotent: if Commit returns an error partway through,
+// it's safe to call Commit again on the same Tx (or let crash recovery
+// replay the WAL) without double-applying ops that already succeeded.
+func (t *Tx) Commit() error {
+	if len(t.ops) == 0 {
+		return nil
+	}
+
+	walDir := filepath.Join(t.driver.dir, walDirName)
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		return err
+	}
+
+	walPath := filepath.Join(walDir, t.id+".log")
+
+	buf, err := encodeWAL(t.ops)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(walPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := t.driver.applyOps(t.ops, true); err != nil {
+		return err
+	}
+
+	t.ops = nil
+	return os.Remove(walPath)
+}
+
+func hashPayload(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeWAL renders ops as the ordered list of JSON records described in
+// the WAL file format, followed by a CRC32 checksum line and a trailing
+// COMMIT marker.
+func encodeWAL(ops []txOp) ([]byte, error) {
+	var buf bytes.Buffer
+
+	for _, op := range ops {
+		rec := walRecord{
+			Op:         op.Kind,
+			Collection: op.Collection,
+			Resource:   op.Resource,
+			Hash:       hashPayload(op.Payload),
+			Payload:    op.Payload,
+		}
+
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	crc := crc32.ChecksumIEEE(buf.Bytes())
+	fmt.Fprintf(&buf, "CRC32:%08x\n", crc)
+	buf.WriteString("COMMIT\n")
+
+	return buf.Bytes(), nil
+}
+
+// decodeWAL parses a WAL file's contents back into the ops it recorded.
+// committed is false if the file has no trailing COMMIT marker or its
+// CRC32 line doesn't match the preceding records, in which case it
+// should be discarded rather than replayed.
+func decodeWAL(data []byte) (ops []txOp, committed bool, err error) {
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	if len(lines) < 2 {
+		return nil, false, nil
+	}
+
+	if string(lines[len(lines)-1]) != "COMMIT" {
+		return nil, false, nil
+	}
+
+	recordLines := lines[:len(lines)-2]
+	crcLine := string(lines[len(lines)-2])
+
+	var recordBytes bytes.Buffer
+	for _, line := range recordLines {
+		recordBytes.Write(line)
+		recordBytes.WriteByte('\n')
+	}
+
+	if want := fmt.Sprintf("CRC32:%08x", crc32.ChecksumIEEE(recordBytes.Bytes())); want != crcLine {
+		return nil, false, nil
+	}
+
+	ops = make([]txOp, 0, len(recordLines))
+	scanner := bufio.NewScanner(bytes.NewReader(recordBytes.Bytes()))
+	for scanner.Scan() {
+		var rec walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, false, err
+		}
+		ops = append(ops, txOp{Kind: rec.Op, Collection: rec.Collection, Resource: rec.Resource, Payload: rec.Payload})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+
+	return ops, true, nil
+}
+
+// applyOps applies ops to the store, locking every collection and
+// record shard touched in a canonical order to avoid deadlocking
+// against a concurrent Commit or single Write/Delete. If idempotent is
+// true, an op that has already taken effect (matching the current
+// on-disk state) is skipped rather than re-applied; Commit always
+// passes true so that retrying a partially-failed commit is safe, and
+// WAL replay relies on the same behavior after a crash.
+func (d *Driver) applyOps(ops []txOp, idempotent bool) error {
+	collections := make(map[string]bool, len(ops))
+	shardIndexes := make(map[uint32]bool, len(ops))
+	for _, op := range ops {
+		collections[op.Collection] = true
+		shardIndexes[d.recordShardIndex(op.Collection, op.Resource)] = true
+	}
+
+	collNames := make([]string, 0, len(collections))
+	for name := range collections {
+		collNames = append(collNames, name)
+	}
+	sort.Strings(collNames)
+
+	// Shards are locked in ascending index order (not record-key order)
+	// so that any two callers locking an overlapping set of shards
+	// always agree on the order, regardless of which record keys
+	// happened to hash into them.
+	shards := make([]uint32, 0, len(shardIndexes))
+	for idx := range shardIndexes {
+		shards = append(shards, idx)
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i] < shards[j] })
+
+	for _, name := range collNames {
+		collLock := d.collectionLock(name)
+		collLock.RLock()
+		defer collLock.RUnlock()
+	}
+
+	for _, idx := range shards {
+		d.recordLocks[idx].Lock()
+		defer d.recordLocks[idx].Unlock()
+	}
+
+	for _, op := range ops {
+		switch op.Kind {
+		case opWrite:
+			if idempotent {
+				fnlPath := filepath.Join(d.dir, op.Collection, op.Resource+d.codec.Ext())
+				if existing, err := os.ReadFile(fnlPath); err == nil && hashPayload(existing) == hashPayload(op.Payload) {
+					continue
+				}
+			}
+			if err := d.writeLocked(op.Collection, op.Resource, op.Payload); err != nil {
+				return err
+			}
+		case opDelete:
+			if idempotent {
+				dir := filepath.Join(d.dir, op.Collection, op.Resource)
+				if _, err := d.stat(dir); err != nil {
+					continue
+				}
+			}
+			if err := d.deleteLocked(op.Collection, op.Resource); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown WAL op %q", op.Kind)
+		}
+	}
+
+	return nil
+}
+
+// recoverWAL replays any committed-but-not-cleaned-up transactions left
+// behind by a crash, and discards any WAL files that never reached a
+// COMMIT marker.
+func (d *Driver) recoverWAL() error {
+	walDir := filepath.Join(d.dir, walDirName)
+
+	entries, err := os.ReadDir(walDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(walDir, entry.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		ops, committed, err := decodeWAL(data)
+		if err != nil || !committed {
+			d.log.Debug("discarding unusable WAL file '%s'\n", path)
+			if rmErr := os.Remove(path); rmErr != nil {
+				return rmErr
+			}
+			continue
+		}
+
+		if err := d.applyOps(ops, true); err != nil {
+			return err
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
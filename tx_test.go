@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRecoverWALDiscardsUncommittedFile verifies that a WAL file missing
+// its trailing COMMIT marker (left behind by a crash before the commit
+// write finished) is discarded rather than replayed.
+func TestRecoverWALDiscardsUncommittedFile(t *testing.T) {
+	dir := t.TempDir()
+	db, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := []txOp{{Kind: opWrite, Collection: "users", Resource: "u1", Payload: []byte(`{"n":1}`)}}
+	buf, err := encodeWAL(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf = buf[:len(buf)-len("COMMIT\n")]
+
+	walDir := filepath.Join(dir, walDirName)
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	walPath := filepath.Join(walDir, "stale.log")
+	if err := os.WriteFile(walPath, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.recoverWAL(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(walPath); !os.IsNotExist(err) {
+		t.Fatalf("expected uncommitted WAL file to be removed, stat err = %v", err)
+	}
+	var v map[string]int
+	if err := db.Read("users", "u1", &v); err == nil {
+		t.Fatal("expected op from an uncommitted WAL file not to have been applied")
+	}
+}
+
+// TestRecoverWALReplaysDisjointWALFiles checks that two WAL files left
+// behind by different transactions, touching unrelated records, both
+// get replayed.
+func TestRecoverWALReplaysDisjointWALFiles(t *testing.T) {
+	dir := t.TempDir()
+	db, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walDir := filepath.Join(dir, walDirName)
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]txOp{
+		"a.log": {{Kind: opWrite, Collection: "users", Resource: "u1", Payload: []byte(`{"n":1}`)}},
+		"b.log": {{Kind: opWrite, Collection: "users", Resource: "u2", Payload: []byte(`{"n":2}`)}},
+	}
+	for name, ops := range files {
+		buf, err := encodeWAL(ops)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(walDir, name), buf, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.recoverWAL(); err != nil {
+		t.Fatal(err)
+	}
+
+	var v map[string]int
+	if err := db.Read("users", "u1", &v); err != nil || v["n"] != 1 {
+		t.Fatalf("u1 not replayed correctly: v=%v err=%v", v, err)
+	}
+	if err := db.Read("users", "u2", &v); err != nil || v["n"] != 2 {
+		t.Fatalf("u2 not replayed correctly: v=%v err=%v", v, err)
+	}
+}
+
+// TestNewFinishesInterruptedCommit simulates a process that crashed
+// after fsyncing a WAL file but before applyOps ran: the record the WAL
+// describes doesn't exist on disk yet. New must replay it and clean up
+// the WAL file.
+func TestNewFinishesInterruptedCommit(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	walDir := filepath.Join(dir, walDirName)
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ops := []txOp{{Kind: opWrite, Collection: "users", Resource: "u1", Payload: []byte(`{"n":1}`)}}
+	buf, err := encodeWAL(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walPath := filepath.Join(walDir, "crashed.log")
+	if err := os.WriteFile(walPath, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v map[string]int
+	if err := db.Read("users", "u1", &v); err != nil {
+		t.Fatalf("crashed commit was not finished by New: %v", err)
+	}
+	if _, err := os.Stat(walPath); !os.IsNotExist(err) {
+		t.Fatalf("expected WAL file to be removed after replay, stat err = %v", err)
+	}
+}
+
+// TestApplyOpsIdempotentRetrySkipsAppliedOps checks that re-applying an
+// already-applied op set - as Commit does when retried after a partial
+// failure - is a no-op rather than an error, even for a delete whose
+// target is already gone.
+func TestApplyOpsIdempotentRetrySkipsAppliedOps(t *testing.T) {
+	dir := t.TempDir()
+	db, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := []txOp{
+		{Kind: opWrite, Collection: "users", Resource: "u1", Payload: []byte(`{"n":1}`)},
+		{Kind: opDelete, Collection: "users", Resource: "ghost"},
+	}
+
+	if err := db.applyOps(ops, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.applyOps(ops, true); err != nil {
+		t.Fatalf("idempotent retry of already-applied ops failed: %v", err)
+	}
+}
+
+// TestConcurrentCommitsDoNotDeadlock exercises applyOps' canonical
+// shard-index lock ordering under real contention: many Txs touching
+// overlapping collections in opposite record order must all complete
+// instead of deadlocking against one another.
+func TestConcurrentCommitsDoNotDeadlock(t *testing.T) {
+	dir := t.TempDir()
+	db, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				tx := db.Begin()
+				tx.Write("a", fmt.Sprintf("r%d", i), map[string]int{"n": i})
+				tx.Write("b", fmt.Sprintf("r%d", 19-i), map[string]int{"n": i})
+				if err := tx.Commit(); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("concurrent commits deadlocked")
+	}
+}